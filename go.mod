@@ -0,0 +1,3 @@
+module github.com/NetScout-Go/Plugin_ble_http_proxy
+
+go 1.21
@@ -7,20 +7,52 @@ to the local NetTool HTTP server and returns the responses over BLE.
 
 import argparse
 import asyncio
-import dbus
-import dbus.exceptions
-import dbus.mainloop.glib
-import dbus.service
 import http.client
+import importlib
+import json
 import logging
 import os
 import signal
 import socket
+import ssl
 import struct
 import sys
 import time
 import threading
 import uuid
+import urllib.parse
+
+# Third-party modules needed by the GATT server, with the Debian packages providing them
+REQUIRED_MODULES = {
+    'dbus': 'python3-dbus',
+    'dbus.mainloop.glib': 'python3-dbus',
+    'gi.repository.GLib': 'python3-gi',
+}
+
+def check_dependencies():
+    """Return the required modules that cannot be imported"""
+    missing = []
+    for module in REQUIRED_MODULES:
+        try:
+            importlib.import_module(module)
+        except ImportError:
+            missing.append(module)
+    return missing
+
+# Handle --check-deps before importing the third-party modules below
+if __name__ == '__main__' and '--check-deps' in sys.argv[1:]:
+    missing = check_dependencies()
+    if missing:
+        packages = sorted(set(REQUIRED_MODULES[m] for m in missing))
+        print(f"Missing Python modules: {', '.join(missing)} (install: {' '.join(packages)})")
+        sys.exit(1)
+    print("All Python dependencies are available")
+    sys.exit(0)
+
+import dbus
+import dbus.exceptions
+import dbus.mainloop.glib
+import dbus.service
 from gi.repository import GLib
 
 # Configure logging
@@ -34,12 +66,69 @@ logging.basicConfig(
 )
 logger = logging.getLogger('nettool-ble-proxy')
 
+# Lifecycle status and the outcome of the BlueZ registrations, written to the
+# status file on every update so the plugin can check this instance's health
+current_status = 'starting'
+registration = {'serviceRegistered': False, 'advertising': False}
+
+# Generation of the last reload handled and the error applying it, if any,
+# which the plugin waits for before reporting the reload
+reload_result = {}
+
+# Protocol transcript, written only when --trace-file is given
+trace_logger = logging.getLogger('nettool-ble-proxy.trace')
+trace_logger.propagate = False
+trace_verbose = False
+
+def setup_trace(trace_file, verbose):
+    """Send the protocol transcript to trace_file"""
+    global trace_verbose
+    handler = logging.FileHandler(trace_file)
+    handler.setFormatter(logging.Formatter('%(asctime)s %(message)s'))
+    trace_logger.addHandler(handler)
+    trace_logger.setLevel(logging.DEBUG)
+    trace_verbose = verbose
+
+def trace_chunk(direction, request_id, flags, length):
+    """Record the framing of a single chunk"""
+    if trace_logger.handlers:
+        trace_logger.debug(f"{direction} chunk id={request_id} flags=0x{flags:02x} "
+                           f"first={bool(flags & 1)} last={bool(flags & 2)} len={length}")
+
+def trace_message(direction, request_id, data):
+    """Record a reassembled request or response, hex-dumped in verbose mode"""
+    if not trace_logger.handlers:
+        return
+    first_line = bytes(data).split(b'\r\n', 1)[0].decode('utf-8', 'replace')
+    trace_logger.debug(f"{direction} message id={request_id} len={len(data)}: {first_line}")
+    if trace_verbose:
+        for offset in range(0, len(data), 16):
+            row = bytes(data[offset:offset + 16])
+            text = ''.join(chr(b) if 32 <= b < 127 else '.' for b in row)
+            trace_logger.debug(f"  {offset:08x}  {row.hex(' '):<47}  {text}")
+
 # BLE Service UUIDs
 BLE_HTTP_PROXY_SERVICE_UUID = '00001234-0000-1000-8000-00805f9b34fb'
 BLE_HTTP_REQUEST_CHAR_UUID = '00001235-0000-1000-8000-00805f9b34fb'
 BLE_HTTP_RESPONSE_CHAR_UUID = '00001236-0000-1000-8000-00805f9b34fb'
 BLE_STATUS_CHAR_UUID = '00001237-0000-1000-8000-00805f9b34fb'
 
+# UUIDs that can be overridden from the command line
+DEFAULT_UUIDS = {
+    'service': BLE_HTTP_PROXY_SERVICE_UUID,
+    'request': BLE_HTTP_REQUEST_CHAR_UUID,
+    'response': BLE_HTTP_RESPONSE_CHAR_UUID,
+}
+
+# Version of the chunk framing spoken over BLE; must match ProtocolVersion in plugin.go
+PROTOCOL_VERSION = 1
+
+# Chunk framing: 16 bytes for request ID, 1 byte for flags
+CHUNK_HEADER_SIZE = 17
+
+# Maximum notification size the response chunks are sized for
+RESPONSE_MTU = 512
+
 # BlueZ D-Bus constants
 BLUEZ_SERVICE_NAME = 'org.bluez'
 ADAPTER_INTERFACE = 'org.bluez.Adapter1'
@@ -52,7 +141,7 @@ DBUS_PROP_INTERFACE = 'org.freedesktop.DBus.Properties'
 LE_ADVERTISING_MANAGER_INTERFACE = 'org.bluez.LEAdvertisingManager1'
 LE_ADVERTISEMENT_INTERFACE = 'org.bluez.LEAdvertisement1'
 
-# Status file for storing the BLE proxy state
+# Default status file for storing the BLE proxy state
 STATUS_FILE = '/tmp/nettool_ble_proxy.status'
 
 class InvalidArgsException(dbus.exceptions.DBusException):
@@ -111,12 +200,13 @@ class HTTPRequest:
 
 class Advertisement(dbus.service.Object):
     """BLE Advertisement object for the HTTP Proxy service"""
-    def __init__(self, bus, index, advertising_type, device_name):
+    def __init__(self, bus, index, advertising_type, device_name,
+                 service_uuid=BLE_HTTP_PROXY_SERVICE_UUID):
         self.path = f"/org/bluez/example/advertisement{index}"
         self.bus = bus
         self.ad_type = advertising_type
         self.device_name = device_name
-        self.service_uuids = [BLE_HTTP_PROXY_SERVICE_UUID]
+        self.service_uuids = [service_uuid]
         self.manufacturer_data = {}
         self.solicit_uuids = []
         self.service_data = {}
@@ -160,12 +250,22 @@ class Advertisement(dbus.service.Object):
 
 class HTTPProxyService(dbus.service.Object):
     """GATT Service for HTTP Proxying"""
-    def __init__(self, bus, index, http_port):
+    def __init__(self, bus, index, http_port, upstream_scheme='http', insecure_skip_verify=False,
+                 uuids=None, upstream_host='localhost', routes=None):
         self.path = f"/org/bluez/example/service{index}"
         self.bus = bus
+        self.uuids = dict(DEFAULT_UUIDS, **(uuids or {}))
         self.http_port = http_port
+        self.upstream_host = upstream_host
+        self.upstream_scheme = upstream_scheme
+        self.insecure_skip_verify = insecure_skip_verify
+        # Guards the upstream settings above, which a reload changes while requests are in flight
+        self.upstream_lock = threading.Lock()
+        # (prefix, host, port) routes, longest prefix first; empty means a single upstream
+        self.routes = sort_routes(routes or [])
         self.pending_requests = {}
         self.next_response_handle = 1
+        self.chunks_sent = 0
         
         dbus.service.Object.__init__(self, bus, self.path)
         
@@ -176,7 +276,7 @@ class HTTPProxyService(dbus.service.Object):
     def get_properties(self):
         return {
             GATT_SERVICE_INTERFACE: {
-                'UUID': BLE_HTTP_PROXY_SERVICE_UUID,
+                'UUID': self.uuids['service'],
                 'Primary': True,
             }
         }
@@ -203,19 +303,27 @@ class HTTPProxyService(dbus.service.Object):
     
     def process_http_request(self, request):
         """Process an HTTP request and send the response"""
+        trace_message('rx', request.request_id, request.data)
         parsed = request.parse()
         if not parsed:
             self.send_error_response(request.request_id, 400, "Bad Request")
             return
         
         try:
-            # Connect to the local HTTP server
-            conn = http.client.HTTPConnection('localhost', self.http_port, timeout=10)
+            # Connect to the upstream serving this path
+            with self.upstream_lock:
+                upstream = self.select_upstream(parsed['path'])
+                if upstream:
+                    conn = self.open_upstream_connection(*upstream)
+                    netloc = format_netloc(*upstream)
+            if not upstream:
+                self.send_error_response(request.request_id, 502, "Bad Gateway")
+                return
             
             # Prepare headers
             headers = parsed['headers']
             if 'Host' not in headers:
-                headers['Host'] = f'localhost:{self.http_port}'
+                headers['Host'] = netloc
             
             # Send the request
             conn.request(parsed['method'], parsed['path'], parsed['body'], headers)
@@ -241,18 +349,51 @@ class HTTPProxyService(dbus.service.Object):
             logger.error(f"Error processing HTTP request: {e}")
             self.send_error_response(request.request_id, 500, f"Internal Server Error: {str(e)}")
     
+    def select_upstream(self, path):
+        """Return the (host, port) serving a request path, or None when no route matches"""
+        if not self.routes:
+            return self.upstream_host, self.http_port
+        
+        path = urllib.parse.urlsplit(path).path or '/'
+        for prefix, host, port in self.routes:
+            if route_matches(prefix, path):
+                return host, port
+        
+        return None
+    
+    def open_upstream_connection(self, host, port):
+        """Open a connection to an upstream HTTP server using the configured scheme"""
+        if self.upstream_scheme == 'https':
+            context = ssl.create_default_context()
+            if self.insecure_skip_verify:
+                # Allow self-signed certificates on the local dashboard
+                context.check_hostname = False
+                context.verify_mode = ssl.CERT_NONE
+            return http.client.HTTPSConnection(host, port, timeout=10, context=context)
+        
+        return http.client.HTTPConnection(host, port, timeout=10)
+    
+    def upstream_netloc(self):
+        """Return the default upstream address as host:port"""
+        return format_netloc(self.upstream_host, self.http_port)
+    
     def send_error_response(self, request_id, status, message):
         """Send an error response for a request"""
         response = f'HTTP/1.1 {status} {message}\r\nContent-Type: text/plain\r\nContent-Length: {len(message)}\r\n\r\n{message}'.encode('utf-8')
         self.send_response(request_id, response)
     
+    def max_chunk_size(self):
+        """Maximum response data carried by a single notification"""
+        return RESPONSE_MTU - CHUNK_HEADER_SIZE
+    
     def send_response(self, request_id, response_data):
         """Send a response in chunks"""
         # Maximum data size per notification
-        max_chunk_size = 512 - 17  # 16 bytes for request ID, 1 byte for flags
+        max_chunk_size = self.max_chunk_size()
         
         # Calculate number of chunks
         total_chunks = (len(response_data) + max_chunk_size - 1) // max_chunk_size
+        trace_message('tx', request_id, response_data)
         
         for i in range(total_chunks):
             start = i * max_chunk_size
@@ -273,10 +414,14 @@ class HTTPProxyService(dbus.service.Object):
             chunk.extend(response_data[start:end])
             
             # Send notification
+            trace_chunk('tx', request_id, flags, end - start)
             self.response_characteristic.send_notification(chunk)
             
             # Small delay to avoid overwhelming the client
             time.sleep(0.01)
+        
+        self.chunks_sent += total_chunks
+        logger.info(f"Sent response for {request_id}: {len(response_data)} bytes in {total_chunks} chunks")
 
 class HTTPRequestCharacteristic(dbus.service.Object):
     """GATT Characteristic for receiving HTTP requests"""
@@ -290,7 +435,7 @@ class HTTPRequestCharacteristic(dbus.service.Object):
     def get_properties(self):
         return {
             GATT_CHARACTERISTIC_INTERFACE: {
-                'UUID': BLE_HTTP_REQUEST_CHAR_UUID,
+                'UUID': self.service.uuids['request'],
                 'Service': self.service.get_path(),
                 'Flags': ['write'],
             }
@@ -329,17 +474,23 @@ class HTTPRequestCharacteristic(dbus.service.Object):
         request_id = received[:16].decode('utf-8').rstrip('\0')
         flags = received[16]
         data = received[17:]
+        trace_chunk('rx', request_id, flags, len(data))
         
         is_first = (flags & 1) != 0
         is_last = (flags & 2) != 0
         
+        # Each connected central gets its own request ID namespace, so two
+        # clients reusing the same ID cannot corrupt each other's requests
+        device = str(options.get('device', ''))
+        key = (device, request_id)
+        
         # Get or create request object
         if is_first:
-            self.service.pending_requests[request_id] = HTTPRequest(request_id)
+            self.service.pending_requests[key] = HTTPRequest(request_id)
         
-        request = self.service.pending_requests.get(request_id)
+        request = self.service.pending_requests.get(key)
         if not request:
-            logger.error(f"Received chunk for unknown request ID: {request_id}")
+            logger.error(f"Received chunk for unknown request ID: {request_id} (device: {device or 'unknown'})")
             return
         
         # Add data to request
@@ -354,7 +505,7 @@ class HTTPRequestCharacteristic(dbus.service.Object):
             ).start()
             
             # Remove from pending requests
-            del self.service.pending_requests[request_id]
+            del self.service.pending_requests[key]
 
 class HTTPResponseCharacteristic(dbus.service.Object):
     """GATT Characteristic for sending HTTP responses"""
@@ -369,7 +520,7 @@ class HTTPResponseCharacteristic(dbus.service.Object):
     def get_properties(self):
         return {
             GATT_CHARACTERISTIC_INTERFACE: {
-                'UUID': BLE_HTTP_RESPONSE_CHAR_UUID,
+                'UUID': self.service.uuids['response'],
                 'Service': self.service.get_path(),
                 'Flags': ['notify'],
             }
@@ -466,13 +617,21 @@ class StatusCharacteristic(dbus.service.Object):
         # Return basic status information
         status = {
             'status': 'running',
+            'protocol_version': PROTOCOL_VERSION,
             'uptime': int(time.time() - start_time),
             'http_port': self.service.http_port,
+            'upstream_addr': self.service.upstream_netloc(),
+            'upstream_scheme': self.service.upstream_scheme,
+            'routes': {prefix: format_netloc(host, port) for prefix, host, port in self.service.routes},
+            # MTU BlueZ negotiated with the reading central, when it reports one
+            'mtu': int(options['mtu']) if 'mtu' in options else None,
+            'response_mtu': RESPONSE_MTU,
+            'max_chunk_size': self.service.max_chunk_size(),
+            'chunks_sent': self.service.chunks_sent,
             'requests_processed': len(self.service.pending_requests)
         }
         
         # Convert to JSON and then to bytes
-        import json
         status_json = json.dumps(status)
         return [ord(c) for c in status_json]
     
@@ -495,7 +654,7 @@ def find_adapter(bus):
     
     return None
 
-def setup_advertisement(bus, device_name):
+def setup_advertisement(bus, device_name, service_uuid=BLE_HTTP_PROXY_SERVICE_UUID):
     """Set up BLE advertisement"""
     adapter_path = find_adapter(bus)
     if not adapter_path:
@@ -504,15 +663,16 @@ def setup_advertisement(bus, device_name):
     adapter = dbus.Interface(bus.get_object(BLUEZ_SERVICE_NAME, adapter_path),
                            LE_ADVERTISING_MANAGER_INTERFACE)
     
-    advertisement = Advertisement(bus, 0, 'peripheral', device_name)
+    advertisement = Advertisement(bus, 0, 'peripheral', device_name, service_uuid)
     
     adapter.RegisterAdvertisement(advertisement.get_path(), {},
-                                reply_handler=lambda: logger.info("Advertisement registered"),
-                                error_handler=lambda error: logger.error(f"Failed to register advertisement: {error}"))
+                                reply_handler=on_advertisement_registered,
+                                error_handler=on_advertisement_error)
     
     return advertisement
 
-def setup_gatt_server(bus, http_port):
+def setup_gatt_server(bus, http_port, upstream_scheme='http', insecure_skip_verify=False, uuids=None,
+                      upstream_host='localhost', routes=None):
     """Set up BLE GATT server"""
     adapter_path = find_adapter(bus)
     if not adapter_path:
@@ -521,20 +681,142 @@ def setup_gatt_server(bus, http_port):
     adapter = dbus.Interface(bus.get_object(BLUEZ_SERVICE_NAME, adapter_path),
                            GATT_MANAGER_INTERFACE)
     
-    service = HTTPProxyService(bus, 0, http_port)
+    service = HTTPProxyService(bus, 0, http_port, upstream_scheme, insecure_skip_verify, uuids,
+                               upstream_host, routes)
     
     adapter.RegisterService(service.get_path(), {},
-                          reply_handler=lambda: logger.info("Service registered"),
-                          error_handler=lambda error: logger.error(f"Failed to register service: {error}"))
+                          reply_handler=on_service_registered,
+                          error_handler=on_service_error)
     
     return service
 
-def update_status_file(status):
-    """Update the status file with current status"""
-    with open(STATUS_FILE, 'w') as f:
-        f.write(f"{status}\n")
-        f.write(f"PID: {os.getpid()}\n")
-        f.write(f"Started: {time.strftime('%Y-%m-%d %H:%M:%S')}\n")
+def upstream_addr_arg(value):
+    """Parse a host:port upstream address, accepting bracketed IPv6 literals"""
+    try:
+        parts = urllib.parse.urlsplit(f'//{value}')
+        host, port = parts.hostname, parts.port
+    except ValueError:
+        host, port = None, None
+    if not host or not port:
+        raise argparse.ArgumentTypeError(f"invalid upstream address: {value} (expected host:port)")
+    return host, port
+
+def route_arg(value):
+    """Parse a PREFIX=host:port route mapping a path prefix to an upstream"""
+    prefix, sep, addr = value.partition('=')
+    if not sep or not prefix.startswith('/'):
+        raise argparse.ArgumentTypeError(f"invalid route: {value} (expected /prefix=host:port)")
+    host, port = upstream_addr_arg(addr)
+    return prefix, host, port
+
+def sort_routes(routes):
+    """Order routes longest prefix first so the most specific route wins"""
+    return sorted(routes, key=lambda route: len(route[0]), reverse=True)
+
+def route_matches(prefix, path):
+    """Check if a path falls under a route prefix, matching whole path segments"""
+    prefix = prefix.rstrip('/')
+    return not prefix or path == prefix or path.startswith(prefix + '/')
+
+def format_netloc(host, port):
+    """Return an address as host:port, bracketing IPv6 literals"""
+    if ':' in host:
+        return f'[{host}]:{port}'
+    return f'{host}:{port}'
+
+def uuid_arg(value):
+    """Validate a UUID command line argument and normalise it to lowercase"""
+    try:
+        return str(uuid.UUID(value))
+    except ValueError:
+        raise argparse.ArgumentTypeError(f"invalid UUID: {value}")
+
+def on_service_registered():
+    """Mark the proxy as running once BlueZ has accepted the GATT service"""
+    logger.info("Service registered")
+    registration['serviceRegistered'] = True
+    update_status_file("running")
+
+def on_service_error(error):
+    """Record that BlueZ rejected the GATT service"""
+    logger.error(f"Failed to register service: {error}")
+    registration['serviceRegistered'] = False
+    update_status_file()
+
+def on_advertisement_registered():
+    """Record that BlueZ accepted the advertisement"""
+    logger.info("Advertisement registered")
+    registration['advertising'] = True
+    update_status_file()
+
+def on_advertisement_error(error):
+    """Record that BlueZ rejected the advertisement"""
+    logger.error(f"Failed to register advertisement: {error}")
+    registration['advertising'] = False
+    update_status_file()
+
+def update_status_file(status=None):
+    """Update the status file, keeping the current status when none is given"""
+    global current_status
+    if status:
+        current_status = status
+    # Write to a temporary file and rename it so readers never see a partial update
+    tmp_file = f"{status_file}.tmp{os.getpid()}"
+    with open(tmp_file, 'w') as f:
+        json.dump({
+            'status': current_status,
+            'pid': os.getpid(),
+            'deviceName': args.device_name,
+            'port': args.port,
+            'startedAt': time.strftime('%Y-%m-%dT%H:%M:%SZ', time.gmtime(start_time)),
+            'serviceUUID': args.service_uuid,
+            **registration,
+            **reload_result,
+        }, f)
+    os.replace(tmp_file, status_file)
+
+def reload_config():
+    """Apply the upstream settings and routes the plugin wrote to the reload file before sending SIGHUP"""
+    generation = None
+    try:
+        with open(reload_file) as f:
+            settings = json.load(f)
+        generation = settings.get('generation')
+        scheme = settings.get('upstreamScheme', 'http')
+        if scheme not in ('http', 'https'):
+            raise ValueError(f"invalid upstream scheme: {scheme}")
+        if settings.get('upstreamAddr'):
+            host, port = upstream_addr_arg(settings['upstreamAddr'])
+        else:
+            host, port = 'localhost', args.port
+        routes = sort_routes([route_arg(route) for route in settings.get('routes') or []])
+    except (OSError, ValueError, argparse.ArgumentTypeError) as e:
+        logger.error(f"Failed to reload config: {e}")
+        acknowledge_reload(generation, str(e))
+        return True
+    
+    # Requests are handled on worker threads, so switch all settings at once
+    with service.upstream_lock:
+        service.upstream_scheme = scheme
+        service.upstream_host = host
+        service.http_port = port
+        service.insecure_skip_verify = bool(settings.get('insecureSkipVerify', False))
+        service.routes = routes
+    logger.info(f"Reloaded config - Upstream: {scheme}://{service.upstream_netloc()}, Routes: {len(routes)}")
+    acknowledge_reload(generation)
+    
+    # Keep the handler installed for the next reload
+    return True
+
+def acknowledge_reload(generation, error=None):
+    """Record the outcome of a reload in the status file for the plugin waiting on it"""
+    if generation is None:
+        return
+    reload_result.clear()
+    reload_result['reloadGeneration'] = generation
+    if error:
+        reload_result['reloadError'] = error
+    update_status_file()
 
 def signal_handler(sig, frame):
     """Handle termination signals"""
@@ -550,6 +832,31 @@ if __name__ == '__main__':
                       help='Bluetooth device name to advertise (default: NetTool)')
     parser.add_argument('--port', type=int, default=8080,
                       help='HTTP port to proxy (default: 8080)')
+    parser.add_argument('--upstream-addr', type=upstream_addr_arg,
+                      help='Upstream host:port to proxy to instead of localhost:<port>')
+    parser.add_argument('--upstream-scheme', choices=['http', 'https'], default='http',
+                      help='Scheme used to reach the local server (default: http)')
+    parser.add_argument('--route', dest='routes', type=route_arg, action='append', default=[],
+                      help='Route requests under a path prefix to an upstream, as /prefix=host:port '
+                           '(repeatable; requests matching no route get a 502)')
+    parser.add_argument('--insecure-skip-verify', action='store_true',
+                      help='Skip TLS certificate verification for the https upstream')
+    parser.add_argument('--status-file', default=STATUS_FILE,
+                      help=f'Status file for this instance (default: {STATUS_FILE})')
+    parser.add_argument('--service-uuid', type=uuid_arg, default=BLE_HTTP_PROXY_SERVICE_UUID,
+                      help='UUID of the HTTP proxy service')
+    parser.add_argument('--request-char-uuid', type=uuid_arg, default=BLE_HTTP_REQUEST_CHAR_UUID,
+                      help='UUID of the HTTP request characteristic')
+    parser.add_argument('--response-char-uuid', type=uuid_arg, default=BLE_HTTP_RESPONSE_CHAR_UUID,
+                      help='UUID of the HTTP response characteristic')
+    parser.add_argument('--trace-file',
+                      help='Write a transcript of chunk framing and messages to this file')
+    parser.add_argument('--verbose', action='store_true',
+                      help='Include hex dumps of full messages in the trace file')
+    parser.add_argument('--version', action='version',
+                      version=f'%(prog)s (protocol version {PROTOCOL_VERSION})')
+    parser.add_argument('--check-deps', action='store_true',
+                      help='Check that the required Python modules are installed and exit')
     args = parser.parse_args()
     
     # Set up signal handlers
@@ -559,8 +866,15 @@ if __name__ == '__main__':
     # Record start time
     start_time = time.time()
     
-    # Update status file
-    update_status_file("running")
+    # Status file for this instance, and the file the plugin leaves settings in for a reload
+    status_file = args.status_file
+    reload_file = f"{status_file}.reload"
+    
+    if args.trace_file:
+        setup_trace(args.trace_file, args.verbose)
+    
+    # Update status file; it switches to running once the service is registered
+    update_status_file("starting")
     
     try:
         # Initialize D-Bus
@@ -568,13 +882,24 @@ if __name__ == '__main__':
         bus = dbus.SystemBus()
         
         # Set up BLE advertisement and GATT server
-        advertisement = setup_advertisement(bus, args.device_name)
-        service = setup_gatt_server(bus, args.port)
+        uuids = {
+            'service': args.service_uuid,
+            'request': args.request_char_uuid,
+            'response': args.response_char_uuid,
+        }
+        advertisement = setup_advertisement(bus, args.device_name, args.service_uuid)
+        upstream_host, upstream_port = args.upstream_addr or ('localhost', args.port)
+        service = setup_gatt_server(bus, upstream_port, args.upstream_scheme, args.insecure_skip_verify, uuids,
+                                    upstream_host, args.routes)
+        
+        # Reload upstream settings on SIGHUP without dropping the advertisement
+        GLib.unix_signal_add(GLib.PRIORITY_DEFAULT, signal.SIGHUP, reload_config)
         
         # Start main loop
         mainloop = GLib.MainLoop()
         
-        logger.info(f"BLE HTTP Proxy service started - Device Name: {args.device_name}, HTTP Port: {args.port}")
+        logger.info(f"BLE HTTP Proxy service started - Device Name: {args.device_name}, Upstream: {args.upstream_scheme}://{service.upstream_netloc()}, "
+                    f"MTU: {RESPONSE_MTU}, Max Chunk Size: {service.max_chunk_size()}")
         mainloop.run()
     except Exception as e:
         logger.error(f"Error starting BLE HTTP Proxy service: {e}")
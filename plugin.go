@@ -4,10 +4,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -15,6 +19,9 @@ import (
 
 // Constants for BLE service
 const (
+	// Version of the chunk framing spoken over BLE; must match pi_zero_ble_service.py
+	ProtocolVersion = 1
+
 	// BLE HTTP Proxy Service (custom UUID)
 	BLEHTTPProxyServiceUUID = "00001234-0000-1000-8000-00805f9b34fb"
 
@@ -30,11 +37,38 @@ const (
 	// Maximum size for BLE attribute value (MTU - 3)
 	MaxBLEAttributeSize = 509
 
-	// Status file for storing the BLE proxy state
+	// Legacy status file shared by all instances; still honoured so proxies
+	// started by older plugin versions can be stopped
 	StatusFile = "/tmp/nettool_ble_proxy.status"
 
+	// Device name and port used when the parameters are not given. Older
+	// plugin versions could only run this instance.
+	DefaultDeviceName = "NetTool"
+	DefaultPort       = 8080
+
+	// Directory holding the per-instance status files
+	StatusFileDir = "/tmp"
+
 	// Python script to run the BLE service
 	PythonScript = "pi_zero_ble_service.py"
+
+	// Maximum bytes read from the end of the script log for error reporting
+	MaxScriptOutputSize = 4096
+
+	// Number of trailing script output lines included in startup errors
+	StartupErrorLines = 5
+
+	// Default maximum time to wait for the proxy to start or stop
+	DefaultWaitTimeout = 10 * time.Second
+
+	// Maximum time to wait for a killed script to exit and finish its output
+	ScriptOutputDrainTimeout = 2 * time.Second
+
+	// Interval between process state checks while waiting
+	ProcessPollInterval = 100 * time.Millisecond
+
+	// Maximum time to wait when checking the upstream address is reachable
+	UpstreamDialTimeout = 3 * time.Second
 )
 
 // BLE HTTP Proxy Plugin for NetTool
@@ -45,6 +79,102 @@ type BLEHTTPProxyPlugin struct {
 // Global plugin instance
 var plugin *BLEHTTPProxyPlugin
 
+// Contents of a status file
+type proxyStatus struct {
+	Status     string `json:"status"`
+	PID        int    `json:"pid,omitempty"`
+	DeviceName string `json:"deviceName,omitempty"`
+	Port       int    `json:"port,omitempty"`
+
+	// Start time in RFC 3339 format
+	StartedAt string `json:"startedAt,omitempty"`
+
+	// UUID of the GATT service the instance registered
+	ServiceUUID string `json:"serviceUUID,omitempty"`
+
+	// Set when the state was produced by simulate mode rather than a real proxy
+	Simulated bool `json:"simulated,omitempty"`
+
+	// Whether BlueZ accepted the instance's GATT service and advertisement,
+	// as recorded by the script; nil for scripts that predate them
+	ServiceRegistered *bool `json:"serviceRegistered,omitempty"`
+	Advertising       *bool `json:"advertising,omitempty"`
+
+	// Generation of the last reload the script handled, and the error it
+	// hit applying it, if any
+	ReloadGeneration int64  `json:"reloadGeneration,omitempty"`
+	ReloadError      string `json:"reloadError,omitempty"`
+}
+
+// Uptime returns how long a running proxy has been up. Clock changes can put
+// the start time in the future, so the result is never negative.
+func (s proxyStatus) Uptime() (time.Duration, bool) {
+	if s.Status != "running" || s.StartedAt == "" {
+		return 0, false
+	}
+
+	started, err := time.Parse(time.RFC3339, s.StartedAt)
+	if err != nil {
+		return 0, false
+	}
+
+	uptime := time.Since(started)
+	if uptime < 0 {
+		uptime = 0
+	}
+
+	return uptime, true
+}
+
+// Settings a running proxy script can apply on reload without restarting
+type reloadSettings struct {
+	UpstreamScheme     string   `json:"upstreamScheme"`
+	UpstreamAddr       string   `json:"upstreamAddr,omitempty"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify"`
+	Routes             []string `json:"routes,omitempty"`
+
+	// Echoed back by the script in the status file once it has handled the reload
+	Generation int64 `json:"generation"`
+}
+
+// Settings passed to the BLE proxy script on start
+type proxyConfig struct {
+	DeviceName string
+	Port       int
+
+	// Scheme used to reach the local HTTP server ("http" or "https")
+	UpstreamScheme string
+
+	// Upstream host:port to proxy to; empty means localhost:<Port>
+	UpstreamAddr string
+
+	// Skip TLS certificate verification for self-signed local certificates
+	InsecureSkipVerify bool
+
+	// Path prefix routes as "/prefix=host:port"; empty means a single upstream
+	Routes []string
+
+	// Service and characteristic UUIDs exposed over BLE
+	ServiceUUID      string
+	RequestCharUUID  string
+	ResponseCharUUID string
+
+	// Status file tracking this proxy instance
+	StatusFile string
+
+	// Maximum time to wait for the proxy to start or stop
+	WaitTimeout time.Duration
+
+	// Skip Bluetooth and the proxy script, only recording state in the status file
+	Simulate bool
+
+	// File receiving a transcript of the chunk framing and messages; empty disables it
+	TraceFile string
+
+	// Include hex dumps of full messages in the trace file
+	Verbose bool
+}
+
 // Plugin is the exported symbol that NetTool will look for
 var Plugin struct {
 	ID          string
@@ -68,14 +198,116 @@ func init() {
 // Plugin execution function
 func executePlugin(params map[string]interface{}) (interface{}, error) {
 	// Extract parameters
-	deviceName := "NetTool"
+	deviceName := DefaultDeviceName
 	if name, ok := params["device_name"].(string); ok && name != "" {
 		deviceName = name
 	}
 
-	port := 8080
-	if p, ok := params["port"].(float64); ok {
-		port = int(p)
+	port := DefaultPort
+	if p, ok := params["port"]; ok && p != nil {
+		var err error
+		port, err = parsePort(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	upstreamScheme := "http"
+	if s, ok := params["upstream_scheme"].(string); ok && s != "" {
+		upstreamScheme = s
+	}
+	if upstreamScheme != "http" && upstreamScheme != "https" {
+		return nil, fmt.Errorf("invalid upstream scheme: %s (must be http or https)", upstreamScheme)
+	}
+
+	upstreamAddr := ""
+	if a, ok := params["upstream_addr"].(string); ok && a != "" {
+		if err := validateUpstreamAddr(a); err != nil {
+			return nil, err
+		}
+		upstreamAddr = a
+	}
+
+	insecureSkipVerify := false
+	if v, ok := params["insecure_skip_verify"].(bool); ok {
+		insecureSkipVerify = v
+	}
+
+	var routes []string
+	if r, ok := params["routes"].(string); ok && r != "" {
+		var err error
+		routes, err = parseRoutes(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	simulate := false
+	if v, ok := params["simulate"].(bool); ok {
+		simulate = v
+	}
+
+	// Only the default instance can still be tracked in the legacy status file
+	var statusFile string
+	legacyInstance := false
+	if f, ok := params["status_file"].(string); ok && f != "" {
+		statusFile = f
+	} else {
+		statusFile = instanceStatusFile(deviceName, port)
+		if deviceName == DefaultDeviceName && port == DefaultPort && !simulate {
+			legacyInstance = true
+			statusFile = resolveStatusFile(statusFile)
+		}
+	}
+	if simulate {
+		statusFile = simulatedStatusFile(statusFile)
+	}
+
+	waitTimeout := DefaultWaitTimeout
+	if t, ok := params["wait_timeout"].(float64); ok && t > 0 {
+		waitTimeout = time.Duration(t * float64(time.Second))
+	}
+
+	// Service and characteristic UUIDs, defaulting to the built-in ones
+	uuids := map[string]string{
+		"service_uuid":       BLEHTTPProxyServiceUUID,
+		"request_char_uuid":  BLEHTTPRequestCharUUID,
+		"response_char_uuid": BLEHTTPResponseCharUUID,
+	}
+	for _, key := range []string{"service_uuid", "request_char_uuid", "response_char_uuid"} {
+		if u, ok := params[key].(string); ok && u != "" {
+			if !isValidUUID(u) {
+				return nil, fmt.Errorf("invalid %s: %s (expected xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx)", key, u)
+			}
+			uuids[key] = strings.ToLower(u)
+		}
+	}
+
+	traceFile := ""
+	if f, ok := params["trace_file"].(string); ok {
+		traceFile = f
+	}
+
+	verbose := false
+	if v, ok := params["verbose"].(bool); ok {
+		verbose = v
+	}
+
+	config := proxyConfig{
+		DeviceName:         deviceName,
+		Port:               port,
+		UpstreamScheme:     upstreamScheme,
+		UpstreamAddr:       upstreamAddr,
+		InsecureSkipVerify: insecureSkipVerify,
+		Routes:             routes,
+		ServiceUUID:        uuids["service_uuid"],
+		RequestCharUUID:    uuids["request_char_uuid"],
+		ResponseCharUUID:   uuids["response_char_uuid"],
+		StatusFile:         statusFile,
+		WaitTimeout:        waitTimeout,
+		Simulate:           simulate,
+		TraceFile:          traceFile,
+		Verbose:            verbose,
 	}
 
 	action := "start"
@@ -84,7 +316,7 @@ func executePlugin(params map[string]interface{}) (interface{}, error) {
 	}
 
 	// Check if BlueZ is available
-	if !isBlueZAvailable() {
+	if !config.Simulate && !isBlueZAvailable() {
 		return nil, fmt.Errorf("BlueZ DBus service is not available. Make sure Bluetooth is enabled and bluetoothd is running")
 	}
 
@@ -94,21 +326,41 @@ func executePlugin(params map[string]interface{}) (interface{}, error) {
 		"message": "",
 		"status":  "",
 	}
+	if config.Simulate {
+		result["simulated"] = true
+	}
+
+	// Serialise state changes so concurrent start/stop requests cannot race
+	if action == "start" || action == "stop" || action == "restart" || action == "reload" {
+		unlock, err := lockStatusFile(config.StatusFile)
+		if err != nil {
+			result["message"] = err.Error()
+			return result, nil
+		}
+		defer unlock()
+
+		if legacyInstance && config.StatusFile != StatusFile {
+			removeStaleLegacyStatusFile()
+		}
+	}
 
 	// Perform the requested action
 	switch action {
 	case "start":
-		err := startBLEProxy(deviceName, port)
+		err := startBLEProxy(config)
 		if err != nil {
 			result["message"] = fmt.Sprintf("Failed to start BLE HTTP proxy: %v", err)
 		} else {
 			result["success"] = true
 			result["message"] = "BLE HTTP proxy started successfully"
 			result["status"] = "running"
+			if state, err := getProxyState(config.StatusFile); err == nil {
+				addStatusDetails(result, state)
+			}
 		}
 
 	case "stop":
-		err := stopBLEProxy()
+		err := stopBLEProxy(config)
 		if err != nil {
 			result["message"] = fmt.Sprintf("Failed to stop BLE HTTP proxy: %v", err)
 		} else {
@@ -117,15 +369,56 @@ func executePlugin(params map[string]interface{}) (interface{}, error) {
 			result["status"] = "stopped"
 		}
 
+	case "restart":
+		statusFile, err := restartBLEProxy(config)
+		if err != nil {
+			result["message"] = fmt.Sprintf("Failed to restart BLE HTTP proxy: %v", err)
+		} else {
+			result["success"] = true
+			result["message"] = "BLE HTTP proxy restarted successfully"
+			result["status"] = "running"
+			if state, err := getProxyState(statusFile); err == nil {
+				addStatusDetails(result, state)
+			}
+		}
+
+	case "reload":
+		err := reloadBLEProxy(config)
+		if err != nil {
+			result["message"] = fmt.Sprintf("Failed to reload BLE HTTP proxy: %v", err)
+		} else {
+			result["success"] = true
+			result["message"] = "BLE HTTP proxy settings reloaded successfully"
+			result["status"] = "running"
+			if state, err := getProxyState(config.StatusFile); err == nil {
+				addStatusDetails(result, state)
+			}
+		}
+
 	case "status":
-		status, err := getBLEProxyStatus()
+		state, err := getProxyState(config.StatusFile)
 		if err != nil {
 			result["message"] = fmt.Sprintf("Failed to get BLE HTTP proxy status: %v", err)
 			result["status"] = "unknown"
 		} else {
 			result["success"] = true
-			result["message"] = fmt.Sprintf("BLE HTTP proxy is %s", status)
-			result["status"] = status
+			result["message"] = fmt.Sprintf("BLE HTTP proxy is %s", state.Status)
+			addStatusDetails(result, state)
+		}
+
+	case "healthcheck":
+		health, err := checkBLEProxyHealth(config)
+		if err != nil {
+			result["message"] = fmt.Sprintf("Failed to check BLE HTTP proxy health: %v", err)
+			result["status"] = "unknown"
+		} else {
+			result["success"] = true
+			result["message"] = health.Message()
+			result["status"] = health.Status
+			result["health"] = health.State()
+			result["processRunning"] = health.ProcessRunning
+			result["serviceRegistered"] = health.ServiceRegistered
+			result["advertising"] = health.Advertising
 		}
 
 	default:
@@ -135,6 +428,134 @@ func executePlugin(params map[string]interface{}) (interface{}, error) {
 	return result, nil
 }
 
+// Copy the details recorded in the status file into a plugin result
+func addStatusDetails(result map[string]interface{}, state proxyStatus) {
+	result["status"] = state.Status
+	result["protocolVersion"] = ProtocolVersion
+	if state.PID > 0 {
+		result["pid"] = state.PID
+	}
+	if state.DeviceName != "" {
+		result["deviceName"] = state.DeviceName
+	}
+	if state.Port > 0 {
+		result["port"] = state.Port
+	}
+	if state.StartedAt != "" {
+		result["startedAt"] = state.StartedAt
+	}
+	if state.ServiceUUID != "" {
+		result["serviceUUID"] = state.ServiceUUID
+	}
+	if state.Simulated {
+		result["simulated"] = true
+	}
+	if uptime, ok := state.Uptime(); ok {
+		result["uptimeSeconds"] = int64(uptime / time.Second)
+	}
+}
+
+// Convert a port parameter to an int and check it is in the valid range.
+// Callers pass the port as a JSON number, a Go int or a numeric string.
+func parsePort(value interface{}) (int, error) {
+	var port int
+	switch v := value.(type) {
+	case float64:
+		if v < 1 || v > 65535 {
+			return 0, fmt.Errorf("invalid port: %v (must be between 1 and 65535)", v)
+		}
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("invalid port: %v (must be a whole number)", v)
+		}
+		port = int(v)
+	case int:
+		port = v
+	case int64:
+		port = int(v)
+	case string:
+		p, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid port: %q (must be a number)", v)
+		}
+		port = p
+	default:
+		return 0, fmt.Errorf("invalid port: unsupported type %T", value)
+	}
+
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %d (must be between 1 and 65535)", port)
+	}
+
+	return port, nil
+}
+
+// Check that an upstream address is host:port with a valid port. IPv6
+// literals must be bracketed, e.g. [fd00::1]:8080.
+func validateUpstreamAddr(addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid upstream address: %s (expected host:port)", addr)
+	}
+
+	if _, err := parsePort(portStr); err != nil {
+		return fmt.Errorf("invalid upstream address: %s: %v", addr, err)
+	}
+
+	return nil
+}
+
+// Parse a comma-separated list of "/prefix=host:port" routes
+func parseRoutes(value string) ([]string, error) {
+	var routes []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, addr, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid route: %s (expected /prefix=host:port)", entry)
+		}
+		if err := validateUpstreamAddr(addr); err != nil {
+			return nil, fmt.Errorf("invalid route %s: %v", prefix, err)
+		}
+		// The script ignores trailing slashes when matching, so /api and /api/ are the same route
+		key := strings.TrimRight(prefix, "/")
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate route: %s", prefix)
+		}
+		seen[key] = true
+
+		routes = append(routes, prefix+"="+addr)
+	}
+
+	return routes, nil
+}
+
+// Check that a string is a well-formed UUID in 8-4-4-4-12 hex form
+func isValidUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 // Check if BlueZ DBus service is available
 func isBlueZAvailable() bool {
 	// Use the bluetoothctl command to check if Bluetooth is available
@@ -155,13 +576,31 @@ func isBlueZAvailable() bool {
 }
 
 // Start the BLE HTTP proxy server
-func startBLEProxy(deviceName string, port int) error {
-	// Check if already running
-	status, _ := getBLEProxyStatus()
-	if status == "running" {
+func startBLEProxy(config proxyConfig) error {
+	// Check if already running. A simulated state left in the real status
+	// file by an older version has no process behind it.
+	current, _ := getProxyState(config.StatusFile)
+	if isProxyActive(current.Status) && (config.Simulate || !current.Simulated) {
 		return fmt.Errorf("BLE HTTP proxy is already running")
 	}
 
+	// In simulate mode only record a running proxy
+	if config.Simulate {
+		return writeStatusFile(config.StatusFile, proxyStatus{
+			Status:      "running",
+			DeviceName:  config.DeviceName,
+			Port:        config.Port,
+			StartedAt:   time.Now().UTC().Format(time.RFC3339),
+			ServiceUUID: config.ServiceUUID,
+			Simulated:   true,
+		})
+	}
+
+	// Fail early when a remote upstream cannot be reached
+	if err := checkUpstreamsReachable(config); err != nil {
+		return err
+	}
+
 	// Get the current plugin directory
 	execPath, err := os.Executable()
 	if err != nil {
@@ -191,10 +630,39 @@ func startBLEProxy(deviceName string, port int) error {
 		}
 	}
 
+	// Make sure the script's Python modules are installed before launching it
+	if output, err := exec.Command(pythonCmd, scriptPath, "--check-deps").CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("python dependency check failed: %s", msg)
+		}
+		return fmt.Errorf("python dependency check failed: %v", err)
+	}
+
 	// Prepare command to run the Python script
-	cmd := exec.Command(pythonCmd, scriptPath,
-		"--device-name", deviceName,
-		"--port", fmt.Sprintf("%d", port))
+	args := []string{scriptPath,
+		"--device-name", config.DeviceName,
+		"--port", fmt.Sprintf("%d", config.Port),
+		"--upstream-scheme", config.UpstreamScheme,
+		"--status-file", config.StatusFile,
+		"--service-uuid", config.ServiceUUID,
+		"--request-char-uuid", config.RequestCharUUID,
+		"--response-char-uuid", config.ResponseCharUUID}
+	if config.UpstreamAddr != "" {
+		args = append(args, "--upstream-addr", config.UpstreamAddr)
+	}
+	if config.InsecureSkipVerify {
+		args = append(args, "--insecure-skip-verify")
+	}
+	for _, route := range config.Routes {
+		args = append(args, "--route", route)
+	}
+	if config.TraceFile != "" {
+		args = append(args, "--trace-file", config.TraceFile)
+		if config.Verbose {
+			args = append(args, "--verbose")
+		}
+	}
+	cmd := exec.Command(pythonCmd, args...)
 
 	// Configure process group for proper termination later
 	cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -204,29 +672,45 @@ func startBLEProxy(deviceName string, port int) error {
 	// Add environment variables if needed
 	cmd.Env = os.Environ()
 
-	// Start the process
-	err = cmd.Start()
+	// Send the script output to the instance's log file so startup failures
+	// can be explained. The script writes to it directly for its whole life,
+	// rather than through a pipe into this process.
+	scriptLog := logFile(config.StatusFile)
+	output, err := os.OpenFile(scriptLog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to start BLE proxy script: %v", err)
+		return fmt.Errorf("failed to create log file: %v", err)
 	}
+	defer output.Close()
+	cmd.Stdout = output
+	cmd.Stderr = output
 
-	// Save PID to the status file in case it doesn't create one
-	pidInfo := fmt.Sprintf("running\nPID: %d\n", cmd.Process.Pid)
-	err = os.WriteFile(StatusFile, []byte(pidInfo), 0644)
+	// Start the process
+	err = cmd.Start()
 	if err != nil {
-		// Try to kill the process since we couldn't create the status file
-		cmd.Process.Kill()
-		return fmt.Errorf("failed to create status file: %v", err)
+		return fmt.Errorf("failed to start BLE proxy script: %v", err)
 	}
 
-	// Wait for service to start
-	time.Sleep(2 * time.Second)
+	// Reap the process when it exits so it does not linger as a zombie
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
 
-	// Verify the service is running by checking status file again
-	status, err = getBLEProxyStatus()
-	if err != nil || status != "running" {
-		// Attempt to kill the process
+	// Wait for the script to report it is running, or to die trying. The
+	// script writes the status file itself, marking itself starting with its
+	// PID and running once its GATT service has been registered.
+	status := waitForStartup(config.StatusFile, cmd.Process.Pid, exited, config.WaitTimeout)
+	if status != "running" {
+		// Attempt to kill the process, and let it finish its output
 		cmd.Process.Kill()
+		select {
+		case <-exited:
+		case <-time.After(ScriptOutputDrainTimeout):
+		}
+		if tail := lastLogLines(scriptLog, StartupErrorLines); tail != "" {
+			return fmt.Errorf("BLE proxy service failed to start properly: %s", tail)
+		}
 		return fmt.Errorf("BLE proxy service failed to start properly")
 	}
 
@@ -234,103 +718,616 @@ func startBLEProxy(deviceName string, port int) error {
 }
 
 // Stop the BLE HTTP proxy server
-func stopBLEProxy() error {
+func stopBLEProxy(config proxyConfig) error {
+	statusFile := config.StatusFile
+
 	// Check if running
-	status, _ := getBLEProxyStatus()
-	if status != "running" {
+	current, _ := getProxyState(statusFile)
+	if !isProxyActive(current.Status) {
 		return fmt.Errorf("BLE HTTP proxy is not running")
 	}
 
+	// A simulated proxy has no process to signal. Its state lives in a separate
+	// file, but a state left by an older version may sit in the real one.
+	if config.Simulate || current.Simulated {
+		return writeStatusFile(statusFile, proxyStatus{
+			Status:     "stopped",
+			DeviceName: current.DeviceName,
+			Port:       current.Port,
+			Simulated:  true,
+		})
+	}
+
 	// Read PID from status file
-	content, err := os.ReadFile(StatusFile)
+	pid, err := readStatusPID(statusFile)
 	if err != nil {
-		return fmt.Errorf("failed to read status file: %v", err)
+		return err
 	}
 
-	// Extract PID
-	lines := strings.Split(string(content), "\n")
-	var pid int
-	for _, line := range lines {
-		if strings.HasPrefix(line, "PID:") {
-			fmt.Sscanf(line, "PID: %d", &pid)
-			break
+	// Send terminate signal
+	err = syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil && isProxyProcess(pid) {
+		// If signaling fails, try to kill the process group, but only once
+		// the PID is confirmed to be the proxy script leading it
+		syscall.Kill(-pid, syscall.SIGTERM)
+	}
+
+	// Wait for service to stop
+	exitErr := waitForProcessExit(pid, config.WaitTimeout)
+	os.Remove(reloadFile(statusFile))
+
+	// Update status file if it wasn't updated by the script
+	state, err := loadStatusFile(statusFile)
+	if exitErr == nil && (err != nil || isProxyActive(state.Status)) {
+		writeStatusFile(statusFile, proxyStatus{
+			Status:     "stopped",
+			DeviceName: state.DeviceName,
+			Port:       state.Port,
+		})
+	}
+
+	return exitErr
+}
+
+// Restart the BLE HTTP proxy server with the given settings, returning the
+// status file now tracking it. A new device name or port makes it a different
+// instance, so the old one is stopped and the new one gets its own file.
+func restartBLEProxy(config proxyConfig) (string, error) {
+	// A restart that stops nothing would leave the old instance running next
+	// to the new one, which happens when the status file is resolved from a
+	// new device name or port
+	current, _ := getProxyState(config.StatusFile)
+	if !isProxyActive(current.Status) {
+		return config.StatusFile, fmt.Errorf("no running instance at %s; pass status_file", config.StatusFile)
+	}
+	if err := stopBLEProxy(config); err != nil {
+		return config.StatusFile, fmt.Errorf("failed to stop running instance: %v", err)
+	}
+
+	if current.DeviceName != "" && (current.DeviceName != config.DeviceName || current.Port != config.Port) {
+		config.StatusFile = instanceStatusFile(config.DeviceName, config.Port)
+		if config.Simulate {
+			config.StatusFile = simulatedStatusFile(config.StatusFile)
+		}
+
+		unlock, err := lockStatusFile(config.StatusFile)
+		if err != nil {
+			return config.StatusFile, err
 		}
+		defer unlock()
+	}
+
+	return config.StatusFile, startBLEProxy(config)
+}
+
+// Apply the upstream settings and routes to a running proxy without restarting it. The
+// settings are written to the instance's reload file and the script picks
+// them up on SIGHUP, keeping its advertisement and connections intact.
+func reloadBLEProxy(config proxyConfig) error {
+	current, _ := getProxyState(config.StatusFile)
+	if current.Status != "running" {
+		return fmt.Errorf("BLE HTTP proxy is not running")
+	}
+
+	// The advertised name and port identify the instance, so changing them needs a restart
+	if current.DeviceName != "" && current.DeviceName != config.DeviceName {
+		return fmt.Errorf("device name changed from %s to %s, use restart to apply it", current.DeviceName, config.DeviceName)
+	}
+	if current.Port != 0 && current.Port != config.Port {
+		return fmt.Errorf("port changed from %d to %d, use restart to apply it", current.Port, config.Port)
+	}
+	if current.ServiceUUID != "" && current.ServiceUUID != config.ServiceUUID {
+		return fmt.Errorf("service UUID changed from %s to %s, use restart to apply it", current.ServiceUUID, config.ServiceUUID)
+	}
+
+	// A simulated proxy has no upstream to update
+	if config.Simulate || current.Simulated {
+		return nil
+	}
+
+	// Older versions tracked the proxy in the legacy text format, and their
+	// script has no SIGHUP handler, so the signal would terminate it
+	if config.StatusFile == StatusFile || current.DeviceName == "" {
+		return fmt.Errorf("BLE HTTP proxy was started by an older plugin version and cannot reload, use restart")
 	}
 
-	if pid == 0 {
+	// Signalling PID 0 would hit our own process group
+	if current.PID <= 0 {
 		return fmt.Errorf("invalid PID in status file")
 	}
 
-	// Send terminate signal
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return fmt.Errorf("failed to find process: %v", err)
+	if err := checkUpstreamsReachable(config); err != nil {
+		return err
 	}
 
-	err = process.Signal(syscall.SIGTERM)
+	content, err := json.Marshal(reloadSettings{
+		UpstreamScheme:     config.UpstreamScheme,
+		UpstreamAddr:       config.UpstreamAddr,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		Routes:             config.Routes,
+		Generation:         current.ReloadGeneration + 1,
+	})
 	if err != nil {
-		// If signaling fails, try to kill the process group
-		syscall.Kill(-pid, syscall.SIGTERM)
+		return err
+	}
+	if err := writeFileAtomic(reloadFile(config.StatusFile), content); err != nil {
+		return fmt.Errorf("failed to write reload file: %v", err)
 	}
 
-	// Wait for service to stop
-	time.Sleep(2 * time.Second)
+	if err := syscall.Kill(current.PID, syscall.SIGHUP); err != nil {
+		return fmt.Errorf("failed to signal process: %v", err)
+	}
 
-	// Update status file if it wasn't updated by the script
-	status, err = getBLEProxyStatus()
-	if err != nil || status == "running" {
-		os.WriteFile(StatusFile, []byte("stopped\n"), 0644)
+	return waitForReload(config.StatusFile, current.ReloadGeneration+1, config.WaitTimeout)
+}
+
+// Poll the status file until the script acknowledges the given reload
+// generation, returning the error it reported applying the settings
+func waitForReload(statusFile string, generation int64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		state, err := getProxyState(statusFile)
+		if err != nil {
+			return err
+		}
+		if state.ReloadGeneration >= generation {
+			if state.ReloadError != "" {
+				return fmt.Errorf("proxy rejected the settings: %s", state.ReloadError)
+			}
+			return nil
+		}
+		if state.Status != "running" {
+			return fmt.Errorf("BLE HTTP proxy stopped before applying the settings")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("BLE HTTP proxy did not acknowledge the reload within %v", timeout)
+		}
+		time.Sleep(ProcessPollInterval)
+	}
+}
+
+// Check that the upstream address and every route's upstream accept
+// connections, so a typo is caught before the proxy starts using it
+func checkUpstreamsReachable(config proxyConfig) error {
+	var addrs []string
+	if config.UpstreamAddr != "" {
+		addrs = append(addrs, config.UpstreamAddr)
+	}
+	for _, route := range config.Routes {
+		_, addr, _ := strings.Cut(route, "=")
+		addrs = append(addrs, addr)
+	}
+
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, UpstreamDialTimeout)
+		if err != nil {
+			return fmt.Errorf("upstream %s is unreachable: %v", addr, err)
+		}
+		conn.Close()
+	}
+
+	return nil
+}
+
+// Wait until the proxy process with the given PID has exited
+func waitForProcessExit(pid int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for isProxyProcess(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("BLE proxy process %d did not exit within %v", pid, timeout)
+		}
+		time.Sleep(ProcessPollInterval)
 	}
 
 	return nil
 }
 
+// Poll the status file until the script with the given PID reports a state
+// past starting, returning "stopped" if it exits first or "starting" once the
+// timeout expires. States recorded by earlier instances are ignored.
+func waitForStartup(statusFile string, pid int, exited <-chan struct{}, timeout time.Duration) string {
+	deadline := time.Now().Add(timeout)
+	for {
+		state, err := loadStatusFile(statusFile)
+		if err == nil && state.PID == pid && state.Status != "starting" {
+			return state.Status
+		}
+
+		select {
+		case <-exited:
+			return "stopped"
+		default:
+		}
+		if time.Now().After(deadline) {
+			return "starting"
+		}
+		time.Sleep(ProcessPollInterval)
+	}
+}
+
+// Check if a status means the proxy process is alive
+func isProxyActive(status string) bool {
+	return status == "running" || status == "starting"
+}
+
+// Read the proxy PID recorded in the status file
+func readStatusPID(statusFile string) (int, error) {
+	state, err := loadStatusFile(statusFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read status file: %v", err)
+	}
+
+	// Signalling PID 0 or a negative PID would hit whole process groups
+	if state.PID <= 0 {
+		return 0, fmt.Errorf("invalid PID in status file")
+	}
+
+	return state.PID, nil
+}
+
 // Get the current status of the BLE HTTP proxy
-func getBLEProxyStatus() (string, error) {
+func getBLEProxyStatus(statusFile string) (string, error) {
+	state, err := getProxyState(statusFile)
+	return state.Status, err
+}
+
+// Get the status file contents, with the status corrected for a proxy
+// process that has died without updating the file
+func getProxyState(statusFile string) (proxyStatus, error) {
+	state, err := loadStatusFile(statusFile)
+	if err != nil {
+		return state, err
+	}
+
+	switch {
+	case isProxyActive(state.Status) && (state.PID < 0 || (state.PID == 0 && !state.Simulated)):
+		// Only a simulated proxy runs without a process; any other state
+		// without a valid PID cannot be verified or signalled
+		state.Status = "unknown"
+	case isProxyActive(state.Status):
+		// Verify PID is actually running
+		if state.PID > 0 && !isProxyProcess(state.PID) {
+			state.Status = "stopped"
+		}
+	case state.Status != "stopped":
+		state.Status = "unknown"
+	}
+
+	return state, nil
+}
+
+// Read and parse the status file. A missing file means the proxy is stopped.
+func loadStatusFile(statusFile string) (proxyStatus, error) {
 	// Check if status file exists
-	_, err := os.Stat(StatusFile)
+	_, err := os.Stat(statusFile)
 	if os.IsNotExist(err) {
-		return "stopped", nil
+		return proxyStatus{Status: "stopped"}, nil
 	}
 
 	// Read status file
-	content, err := os.ReadFile(StatusFile)
+	content, err := os.ReadFile(statusFile)
 	if err != nil {
-		return "unknown", err
+		return proxyStatus{Status: "unknown"}, err
+	}
+
+	return parseStatusFile(content), nil
+}
+
+// Parse status file contents, accepting both the JSON format and the legacy
+// "running\nPID: 123\n" text format written by older versions
+func parseStatusFile(content []byte) proxyStatus {
+	var state proxyStatus
+	if json.Unmarshal(content, &state) == nil {
+		return state
 	}
 
+	state = proxyStatus{}
 	lines := strings.Split(string(content), "\n")
-	if len(lines) > 0 {
-		status := strings.TrimSpace(lines[0])
-		if status == "running" {
-			// Verify PID is actually running
-			for _, line := range lines {
-				if strings.HasPrefix(line, "PID:") {
-					var pid int
-					fmt.Sscanf(line, "PID: %d", &pid)
-					if pid > 0 {
-						process, err := os.FindProcess(pid)
-						if err != nil || process == nil {
-							return "stopped", nil
-						}
-
-						// On Unix, FindProcess always succeeds, so we need to send a signal 0
-						// to check if the process exists
-						err = process.Signal(syscall.Signal(0))
-						if err != nil {
-							return "stopped", nil
-						}
-					}
-					break
-				}
+	state.Status = strings.TrimSpace(lines[0])
+	for _, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "PID:"):
+			fmt.Sscanf(line, "PID: %d", &state.PID)
+		case strings.HasPrefix(line, "Started:"):
+			started := strings.TrimSpace(strings.TrimPrefix(line, "Started:"))
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", started, time.Local); err == nil {
+				state.StartedAt = t.UTC().Format(time.RFC3339)
 			}
-			return "running", nil
-		} else if status == "stopped" {
-			return "stopped", nil
 		}
 	}
 
-	return "unknown", nil
+	return state
+}
+
+// Result of checking whether the BLE proxy is actually reachable over BLE
+type healthReport struct {
+	Status            string
+	ProcessRunning    bool
+	ServiceRegistered bool
+	Advertising       bool
+}
+
+// State summarises the report as "healthy", "not_running",
+// "service_not_registered" or "not_advertising"
+func (h healthReport) State() string {
+	switch {
+	case !h.ProcessRunning:
+		return "not_running"
+	case !h.ServiceRegistered:
+		return "service_not_registered"
+	case !h.Advertising:
+		return "not_advertising"
+	default:
+		return "healthy"
+	}
+}
+
+// Message describes the report for display in the dashboard
+func (h healthReport) Message() string {
+	switch h.State() {
+	case "not_running":
+		return "BLE HTTP proxy is not running"
+	case "service_not_registered":
+		return "BLE HTTP proxy process is running but the GATT service is not registered"
+	case "not_advertising":
+		return "BLE HTTP proxy process is running but the adapter is not advertising"
+	default:
+		return "BLE HTTP proxy is running and advertising"
+	}
+}
+
+// Check that the proxy process is running, its GATT service is registered
+// with BlueZ and the adapter is advertising
+func checkBLEProxyHealth(config proxyConfig) (healthReport, error) {
+	state, err := getProxyState(config.StatusFile)
+	if err != nil {
+		return healthReport{}, err
+	}
+
+	health := healthReport{
+		Status:         state.Status,
+		ProcessRunning: isProxyActive(state.Status),
+	}
+	if !health.ProcessRunning {
+		return health, nil
+	}
+
+	// A simulated proxy is always reported as fully healthy
+	if state.Simulated {
+		health.ServiceRegistered = true
+		health.Advertising = true
+		return health, nil
+	}
+
+	// The script records the outcome of its own registrations, which tells
+	// this instance apart from anything else using the adapter
+	recorded := state.ServiceRegistered != nil && state.Advertising != nil
+	if recorded {
+		health.ServiceRegistered = *state.ServiceRegistered
+		health.Advertising = *state.Advertising
+	}
+
+	// Look for the service the instance was started with; proxies started
+	// before it was recorded always used the default UUID
+	serviceUUID := state.ServiceUUID
+	if serviceUUID == "" {
+		serviceUUID = BLEHTTPProxyServiceUUID
+	}
+
+	listed, advertising, err := adapterRegistrations(serviceUUID)
+	if err != nil {
+		if recorded {
+			return health, nil
+		}
+		return health, err
+	}
+
+	if recorded {
+		// The adapter is only a secondary check, catching registrations
+		// bluetoothd dropped after the script recorded them
+		health.ServiceRegistered = health.ServiceRegistered && listed
+		health.Advertising = health.Advertising && advertising
+	} else {
+		health.ServiceRegistered = listed
+		health.Advertising = advertising
+	}
+
+	return health, nil
+}
+
+// Check if the adapter lists a GATT service with the given UUID and has an
+// active advertisement
+func adapterRegistrations(serviceUUID string) (listed bool, advertising bool, err error) {
+	// Registered GATT services are listed among the adapter UUIDs and the
+	// active advertisement count is shown under the advertising features
+	output, err := exec.Command("bluetoothctl", "show").Output()
+	if err != nil {
+		return false, false, fmt.Errorf("failed to query Bluetooth adapter: %v", err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(strings.ToLower(line), serviceUUID) {
+			listed = true
+		}
+		if strings.HasPrefix(line, "ActiveInstances:") {
+			var instances int
+			fmt.Sscanf(strings.TrimSpace(strings.TrimPrefix(line, "ActiveInstances:")), "0x%x", &instances)
+			advertising = instances > 0
+		}
+	}
+
+	return listed, advertising, nil
+}
+
+// Return up to n trailing non-empty lines of a script log, reading at most
+// MaxScriptOutputSize bytes from its end
+func lastLogLines(path string, n int) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && info.Size() > MaxScriptOutputSize {
+		f.Seek(info.Size()-MaxScriptOutputSize, io.SeekStart)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, MaxScriptOutputSize))
+	if err != nil {
+		return ""
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Write the status file atomically so readers never see a partial update
+func writeStatusFile(statusFile string, state proxyStatus) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(statusFile, content)
+}
+
+// Write a file via a temporary file and rename so it is replaced in one step
+func writeFileAtomic(file string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// Path of the status file for a simulated instance. It is kept apart from the
+// real instance's file so simulate mode never signals or blocks a real proxy.
+func simulatedStatusFile(statusFile string) string {
+	return statusFile + ".sim"
+}
+
+// Path of the file a proxy instance's script output is written to
+func logFile(statusFile string) string {
+	return statusFile + ".log"
+}
+
+// Path of the file a proxy instance reads its reloaded settings from
+func reloadFile(statusFile string) string {
+	return statusFile + ".reload"
+}
+
+// Take an exclusive lock on a proxy instance for the duration of a state
+// change. The returned function releases the lock.
+func lockStatusFile(statusFile string) (func(), error) {
+	f, err := os.OpenFile(statusFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("another start or stop of this BLE HTTP proxy is in progress")
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// Build the status file path for a proxy instance from its device name and
+// port. Bytes outside [A-Za-z0-9_-] are percent-escaped so that distinct
+// names never share a file.
+func instanceStatusFile(deviceName string, port int) string {
+	var name strings.Builder
+	for _, b := range []byte(deviceName) {
+		if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '-' || b == '_' {
+			name.WriteByte(b)
+		} else {
+			fmt.Fprintf(&name, "%%%02X", b)
+		}
+	}
+
+	return filepath.Join(StatusFileDir, fmt.Sprintf("nettool_ble_proxy_%s_%d.status", name.String(), port))
+}
+
+// Resolve which status file the default instance should use. A proxy started
+// by an older plugin version is tracked in the legacy shared file, so keep
+// using that file while it is still running. This only reads the files.
+func resolveStatusFile(statusFile string) string {
+	if _, err := os.Stat(statusFile); err == nil {
+		return statusFile
+	}
+
+	if status, _ := getBLEProxyStatus(StatusFile); isProxyActive(status) {
+		return StatusFile
+	}
+
+	return statusFile
+}
+
+// Remove the legacy status file once the proxy it tracked has stopped. Call
+// only while holding the instance lock.
+func removeStaleLegacyStatusFile() {
+	if _, err := os.Stat(StatusFile); err != nil {
+		return
+	}
+
+	if status, _ := getBLEProxyStatus(StatusFile); !isProxyActive(status) {
+		os.Remove(StatusFile)
+	}
+}
+
+// Check if the given PID is alive and still belongs to the BLE proxy script.
+// PIDs get recycled, so a live process alone does not mean the proxy is running.
+func isProxyProcess(pid int) bool {
+	// Probe with signal 0 directly; os.FindProcess would open a pidfd on
+	// every poll that is only released by the garbage collector
+	if err := syscall.Kill(pid, syscall.Signal(0)); err != nil {
+		return false
+	}
+
+	// Verify the command line references our Python script
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		// Without procfs the signal check is the best we can do
+		if _, statErr := os.Stat("/proc/self"); statErr != nil {
+			return true
+		}
+		return false
+	}
+
+	for _, arg := range strings.Split(string(cmdline), "\x00") {
+		if filepath.Base(arg) == PythonScript {
+			return true
+		}
+	}
+
+	return false
 }
 
 func main() {}
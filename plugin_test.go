@@ -0,0 +1,679 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Build a simulate-mode config tracked in a temporary status file
+func testConfig(t *testing.T) proxyConfig {
+	t.Helper()
+
+	return proxyConfig{
+		DeviceName:  "NetTool",
+		Port:        8080,
+		ServiceUUID: BLEHTTPProxyServiceUUID,
+		StatusFile:  filepath.Join(t.TempDir(), "proxy.status"),
+		WaitTimeout: time.Second,
+		Simulate:    true,
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    int
+		wantErr bool
+	}{
+		{"json number", float64(8080), 8080, false},
+		{"int", 80, 80, false},
+		{"int64", int64(443), 443, false},
+		{"string", " 9090 ", 9090, false},
+		{"fraction", 80.5, 0, true},
+		{"huge float", 1e20, 0, true},
+		{"zero", 0, 0, true},
+		{"too large", 65536, 0, true},
+		{"not a number", "http", 0, true},
+		{"unsupported type", true, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePort(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePort(%v) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parsePort(%v) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"single", "/api=localhost:9090", []string{"/api=localhost:9090"}, false},
+		{"several with spaces", "/api=localhost:9090, /=localhost:8080", []string{"/api=localhost:9090", "/=localhost:8080"}, false},
+		{"ipv6 upstream", "/v6=[::1]:80", []string{"/v6=[::1]:80"}, false},
+		{"empty entries skipped", "/a=h:1,,", []string{"/a=h:1"}, false},
+		{"missing slash", "api=localhost:9090", nil, true},
+		{"missing address", "/api", nil, true},
+		{"missing port", "/api=localhost", nil, true},
+		{"duplicate prefix", "/a=h:1,/a=h:2", nil, true},
+		{"duplicate after trailing slash", "/api=a:1,/api/=b:2", nil, true},
+		{"duplicate root", "/=a:1,//=b:2", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRoutes(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRoutes(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseRoutes(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseRoutes(%q) = %q, want %q", tt.value, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsValidUUID(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{BLEHTTPProxyServiceUUID, true},
+		{"0000ABCD-0000-1000-8000-00805F9B34FB", true},
+		{"00001234-0000-1000-8000-00805f9b34f", false},
+		{"00001234000010008000-00805f9b34fbaa", false},
+		{"0000123g-0000-1000-8000-00805f9b34fb", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidUUID(tt.value); got != tt.want {
+			t.Errorf("isValidUUID(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatusFile(t *testing.T) {
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		content string
+		want    proxyStatus
+	}{
+		{
+			"json",
+			`{"status":"running","pid":1234,"deviceName":"NetTool","port":8080,"startedAt":"2026-01-01T12:00:00Z","serviceUUID":"00001234-0000-1000-8000-00805f9b34fb"}`,
+			proxyStatus{Status: "running", PID: 1234, DeviceName: "NetTool", Port: 8080, StartedAt: "2026-01-01T12:00:00Z", ServiceUUID: BLEHTTPProxyServiceUUID},
+		},
+		{
+			"json with registrations",
+			`{"status":"running","pid":1234,"serviceRegistered":true,"advertising":false}`,
+			proxyStatus{Status: "running", PID: 1234, ServiceRegistered: boolPtr(true), Advertising: boolPtr(false)},
+		},
+		{
+			"json simulated",
+			`{"status":"stopped","simulated":true}`,
+			proxyStatus{Status: "stopped", Simulated: true},
+		},
+		{
+			"legacy",
+			"running\nPID: 42\n",
+			proxyStatus{Status: "running", PID: 42},
+		},
+		{
+			"legacy with start time",
+			"running\nPID: 42\nStarted: " + started.Format("2006-01-02 15:04:05") + "\n",
+			proxyStatus{Status: "running", PID: 42, StartedAt: started.UTC().Format(time.RFC3339)},
+		},
+		{
+			"legacy stopped",
+			"stopped\n",
+			proxyStatus{Status: "stopped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStatusFile([]byte(tt.content)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStatusFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyStatusUptime(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  proxyStatus
+		min    time.Duration
+		max    time.Duration
+		wantOK bool
+	}{
+		{"running", proxyStatus{Status: "running", StartedAt: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)}, 59 * time.Second, 62 * time.Second, true},
+		{"start in the future", proxyStatus{Status: "running", StartedAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}, 0, 0, true},
+		{"stopped", proxyStatus{Status: "stopped", StartedAt: time.Now().UTC().Format(time.RFC3339)}, 0, 0, false},
+		{"no start time", proxyStatus{Status: "running"}, 0, 0, false},
+		{"bad start time", proxyStatus{Status: "running", StartedAt: "yesterday"}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.state.Uptime()
+			if ok != tt.wantOK {
+				t.Fatalf("Uptime() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got < tt.min || got > tt.max {
+				t.Errorf("Uptime() = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestLockStatusFileContention(t *testing.T) {
+	statusFile := filepath.Join(t.TempDir(), "proxy.status")
+
+	unlock, err := lockStatusFile(statusFile)
+	if err != nil {
+		t.Fatalf("lockStatusFile() error = %v", err)
+	}
+
+	if _, err := lockStatusFile(statusFile); err == nil {
+		t.Fatal("second lockStatusFile() succeeded while the lock was held")
+	}
+
+	unlock()
+
+	unlock, err = lockStatusFile(statusFile)
+	if err != nil {
+		t.Fatalf("lockStatusFile() after unlock error = %v", err)
+	}
+	unlock()
+}
+
+func TestSimulatedStartStopWithConcurrentStatusReads(t *testing.T) {
+	config := testConfig(t)
+
+	// Readers must only ever see complete states while the proxy cycles
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				state, err := getProxyState(config.StatusFile)
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				if state.Status != "running" && state.Status != "stopped" {
+					errs <- "unexpected status " + state.Status
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		unlock, err := lockStatusFile(config.StatusFile)
+		if err != nil {
+			t.Fatalf("cycle %d: lockStatusFile() error = %v", i, err)
+		}
+		if err := startBLEProxy(config); err != nil {
+			unlock()
+			t.Fatalf("cycle %d: startBLEProxy() error = %v", i, err)
+		}
+		if err := startBLEProxy(config); err == nil {
+			unlock()
+			t.Fatalf("cycle %d: second startBLEProxy() succeeded", i)
+		}
+		if err := stopBLEProxy(config); err != nil {
+			unlock()
+			t.Fatalf("cycle %d: stopBLEProxy() error = %v", i, err)
+		}
+		unlock()
+	}
+
+	close(done)
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+
+	state, err := getProxyState(config.StatusFile)
+	if err != nil || state.Status != "stopped" || !state.Simulated {
+		t.Errorf("final state = %+v, %v; want a stopped simulated proxy", state, err)
+	}
+}
+
+func TestInstanceStatusFile(t *testing.T) {
+	tests := []struct {
+		deviceName string
+		port       int
+		want       string
+	}{
+		{"NetTool", 8080, "nettool_ble_proxy_NetTool_8080.status"},
+		{"Net_Tool", 8080, "nettool_ble_proxy_Net_Tool_8080.status"},
+		{"Net Tool", 8080, "nettool_ble_proxy_Net%20Tool_8080.status"},
+		{"Net%20Tool", 8080, "nettool_ble_proxy_Net%2520Tool_8080.status"},
+		{"../x", 9090, "nettool_ble_proxy_%2E%2E%2Fx_9090.status"},
+	}
+
+	seen := make(map[string]string)
+	for _, tt := range tests {
+		got := instanceStatusFile(tt.deviceName, tt.port)
+		if want := filepath.Join(StatusFileDir, tt.want); got != want {
+			t.Errorf("instanceStatusFile(%q, %d) = %q, want %q", tt.deviceName, tt.port, got, want)
+		}
+		if other, ok := seen[got]; ok {
+			t.Errorf("instanceStatusFile(%q) and instanceStatusFile(%q) share %q", tt.deviceName, other, got)
+		}
+		seen[got] = tt.deviceName
+	}
+}
+
+func TestRestartWithNewDeviceNameMovesInstance(t *testing.T) {
+	config := testConfig(t)
+	if err := startBLEProxy(config); err != nil {
+		t.Fatalf("startBLEProxy() error = %v", err)
+	}
+
+	// Restart the instance under a new name, pointing at the old status file
+	renamed := config
+	renamed.DeviceName = fmt.Sprintf("nettool-test-%d", os.Getpid())
+	newFile := simulatedStatusFile(instanceStatusFile(renamed.DeviceName, renamed.Port))
+	t.Cleanup(func() {
+		os.Remove(newFile)
+		os.Remove(newFile + ".lock")
+	})
+
+	statusFile, err := restartBLEProxy(renamed)
+	if err != nil {
+		t.Fatalf("restartBLEProxy() error = %v", err)
+	}
+	if statusFile != newFile {
+		t.Errorf("restartBLEProxy() status file = %q, want %q", statusFile, newFile)
+	}
+
+	if old, _ := getProxyState(config.StatusFile); old.Status != "stopped" {
+		t.Errorf("old instance status = %q, want stopped", old.Status)
+	}
+	if state, _ := getProxyState(newFile); state.Status != "running" || state.DeviceName != renamed.DeviceName {
+		t.Errorf("new instance = %+v, want running as %q", state, renamed.DeviceName)
+	}
+}
+
+func TestRestartRequiresRunningInstance(t *testing.T) {
+	statusFile := filepath.Join(t.TempDir(), "proxy.status")
+	run := func(params map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		params["simulate"] = true
+		result, err := executePlugin(params)
+		if err != nil {
+			t.Fatalf("executePlugin(%v) error = %v", params, err)
+		}
+		return result.(map[string]interface{})
+	}
+
+	if result := run(map[string]interface{}{"action": "start", "status_file": statusFile}); result["success"] != true {
+		t.Fatalf("start = %v", result)
+	}
+
+	// Without status_file the new name resolves to a file with nothing in it
+	renamed := fmt.Sprintf("nettool-test-%d", os.Getpid())
+	newFile := simulatedStatusFile(instanceStatusFile(renamed, DefaultPort))
+	t.Cleanup(func() {
+		os.Remove(newFile)
+		os.Remove(newFile + ".lock")
+	})
+
+	result := run(map[string]interface{}{"action": "restart", "device_name": renamed})
+	if result["success"] != false {
+		t.Errorf("restart without status_file = %v, want a failure", result)
+	}
+	if state, _ := getProxyState(newFile); isProxyActive(state.Status) {
+		t.Errorf("restart without status_file started a second proxy: %+v", state)
+	}
+	if state, _ := getProxyState(simulatedStatusFile(statusFile)); state.Status != "running" {
+		t.Errorf("original instance status = %q, want running", state.Status)
+	}
+
+	result = run(map[string]interface{}{"action": "restart", "device_name": renamed, "status_file": statusFile})
+	if result["success"] != true || result["deviceName"] != renamed {
+		t.Errorf("restart with status_file = %v, want the renamed instance running", result)
+	}
+}
+
+func TestSimulateKeepsStateApartFromRealInstance(t *testing.T) {
+	statusFile := filepath.Join(t.TempDir(), "proxy.status")
+	params := map[string]interface{}{
+		"status_file": statusFile,
+		"simulate":    true,
+		"action":      "start",
+	}
+
+	result, err := executePlugin(params)
+	if err != nil || result.(map[string]interface{})["success"] != true {
+		t.Fatalf("simulated start = %v, %v", result, err)
+	}
+
+	if _, err := os.Stat(statusFile); !os.IsNotExist(err) {
+		t.Errorf("simulated start touched the real status file: %v", err)
+	}
+	if state, _ := getProxyState(simulatedStatusFile(statusFile)); state.Status != "running" || !state.Simulated {
+		t.Errorf("simulated state = %+v, want a running simulated proxy", state)
+	}
+}
+
+func TestRealStartIgnoresStaleSimulatedState(t *testing.T) {
+	config := testConfig(t)
+	if err := startBLEProxy(config); err != nil {
+		t.Fatalf("startBLEProxy() error = %v", err)
+	}
+
+	// A real start gets past the running check and fails later for want of
+	// the script, rather than reporting the simulated proxy as running
+	config.Simulate = false
+	config.UpstreamAddr = "127.0.0.1:1"
+	if err := startBLEProxy(config); err == nil || err.Error() == "BLE HTTP proxy is already running" {
+		t.Errorf("real startBLEProxy() error = %v, want it past the running check", err)
+	}
+
+	// Stopping it must not try to signal a process
+	if err := stopBLEProxy(config); err != nil {
+		t.Errorf("stopBLEProxy() error = %v", err)
+	}
+}
+
+func TestReloadRefusesUnsignallableProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"legacy text format", "running\n"},
+		{"no device name", `{"status":"running","pid":123456789}`},
+		{"no pid", `{"status":"running","deviceName":"NetTool","port":8080}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			config.Simulate = false
+			if err := os.WriteFile(config.StatusFile, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Reaching syscall.Kill with PID 0 would signal the test binary itself
+			if err := reloadBLEProxy(config); err == nil {
+				t.Error("reloadBLEProxy() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestCheckUpstreamsReachableDialsRoutes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	config := testConfig(t)
+	config.Routes = []string{"/api=" + listener.Addr().String()}
+	if err := checkUpstreamsReachable(config); err != nil {
+		t.Errorf("checkUpstreamsReachable() with a listening route error = %v", err)
+	}
+
+	config.Routes = append(config.Routes, "/=127.0.0.1:1")
+	if err := checkUpstreamsReachable(config); err == nil {
+		t.Error("checkUpstreamsReachable() with an unreachable route succeeded")
+	}
+}
+
+func TestStopRefusesInvalidPIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"negative pid", `{"status":"running","pid":-1,"deviceName":"NetTool","port":8080}`},
+		{"zero pid", `{"status":"running","pid":0,"deviceName":"NetTool","port":8080}`},
+		{"legacy negative pid", "running\nPID: -1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			config.Simulate = false
+			if err := os.WriteFile(config.StatusFile, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if state, _ := getProxyState(config.StatusFile); isProxyActive(state.Status) {
+				t.Errorf("getProxyState() status = %q, want an inactive state", state.Status)
+			}
+			if _, err := readStatusPID(config.StatusFile); err == nil {
+				t.Error("readStatusPID() accepted an invalid PID")
+			}
+
+			// Getting past the checks would signal every reachable process
+			if err := stopBLEProxy(config); err == nil {
+				t.Error("stopBLEProxy() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// Start a shell standing in for the proxy script, running onHUP on SIGHUP.
+// The process is recognised as the script by the name it is given as $0.
+func startFakeScript(t *testing.T, onHUP string) int {
+	t.Helper()
+
+	// The ready file shows the trap is installed before anything signals it
+	dir := t.TempDir()
+	ready := filepath.Join(dir, "ready")
+	script := ": > " + ready + "; while :; do sleep 0.05; done"
+	if onHUP != "" {
+		script = "trap '" + onHUP + "' HUP; " + script
+	}
+	cmd := exec.Command("sh", "-c", script, filepath.Join(dir, PythonScript))
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start a shell: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stand-in process never became ready")
+		}
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestHealthUsesRecordedRegistrations(t *testing.T) {
+	if _, err := exec.LookPath("bluetoothctl"); err == nil {
+		t.Skip("bluetoothctl would report the real adapter")
+	}
+	pid := startFakeScript(t, "")
+
+	tests := []struct {
+		name              string
+		serviceRegistered bool
+		advertising       bool
+		want              string
+	}{
+		{"both registered", true, true, "healthy"},
+		{"service rejected", false, true, "service_not_registered"},
+		{"advertisement rejected", true, false, "not_advertising"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			config.Simulate = false
+			content := fmt.Sprintf(`{"status":"running","pid":%d,"serviceRegistered":%t,"advertising":%t}`,
+				pid, tt.serviceRegistered, tt.advertising)
+			if err := os.WriteFile(config.StatusFile, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			health, err := checkBLEProxyHealth(config)
+			if err != nil {
+				t.Fatalf("checkBLEProxyHealth() error = %v", err)
+			}
+			if got := health.State(); got != tt.want {
+				t.Errorf("checkBLEProxyHealth() state = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadWaitsForAcknowledgement(t *testing.T) {
+	tests := []struct {
+		name    string
+		ack     string
+		wantErr string
+	}{
+		{"applied", `"reloadGeneration":1`, ""},
+		{"rejected", `"reloadGeneration":1,"reloadError":"invalid route"`, "invalid route"},
+		{"never acknowledged", "", "did not acknowledge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			config.Simulate = false
+			config.WaitTimeout = 500 * time.Millisecond
+
+			ackFile := filepath.Join(t.TempDir(), "ack.status")
+			onHUP := ":"
+			if tt.ack != "" {
+				// Replace the status file atomically, as the script does
+				onHUP = "cp " + ackFile + " " + ackFile + ".tmp && mv " + ackFile + ".tmp " + config.StatusFile
+			}
+			pid := startFakeScript(t, onHUP)
+
+			state := fmt.Sprintf(`"status":"running","pid":%d,"deviceName":"NetTool","port":8080,"serviceUUID":%q`, pid, BLEHTTPProxyServiceUUID)
+			if err := os.WriteFile(ackFile, []byte("{"+state+","+tt.ack+"}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(config.StatusFile, []byte("{"+state+"}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := reloadBLEProxy(config)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("reloadBLEProxy() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadRefusesNewServiceUUID(t *testing.T) {
+	config := testConfig(t)
+	if err := startBLEProxy(config); err != nil {
+		t.Fatalf("startBLEProxy() error = %v", err)
+	}
+
+	config.ServiceUUID = "0000abcd-0000-1000-8000-00805f9b34fb"
+	if err := reloadBLEProxy(config); err == nil {
+		t.Error("reloadBLEProxy() with a new service UUID succeeded")
+	}
+}
+
+func TestWaitForStartupIgnoresOtherInstances(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		exited  bool
+		want    string
+	}{
+		{"running", `{"status":"running","pid":100}`, false, "running"},
+		{"stale running state", `{"status":"running","pid":99}`, true, "stopped"},
+		{"still starting", `{"status":"starting","pid":100}`, false, "starting"},
+		{"no status file yet", "", true, "stopped"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusFile := filepath.Join(t.TempDir(), "proxy.status")
+			if tt.content != "" {
+				if err := os.WriteFile(statusFile, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			exited := make(chan struct{})
+			if tt.exited {
+				close(exited)
+			}
+
+			if got := waitForStartup(statusFile, 100, exited, 200*time.Millisecond); got != tt.want {
+				t.Errorf("waitForStartup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastLogLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.status.log")
+	if got := lastLogLines(path, StartupErrorLines); got != "" {
+		t.Errorf("lastLogLines() without a log = %q, want empty", got)
+	}
+
+	// Only the end of a long log is read
+	var content []byte
+	for i := 0; len(content) <= 2*MaxScriptOutputSize; i++ {
+		content = append(content, fmt.Sprintf("line %d\n", i)...)
+	}
+	content = append(content, "\nTraceback (most recent call last):\n\nImportError: no module named dbus\n"...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Traceback (most recent call last):\nImportError: no module named dbus"
+	if got := lastLogLines(path, 2); got != want {
+		t.Errorf("lastLogLines() = %q, want %q", got, want)
+	}
+}
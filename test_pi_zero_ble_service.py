@@ -0,0 +1,206 @@
+#!/usr/bin/env python3
+"""
+Unit tests for the BLE HTTP Proxy service that run without BlueZ.
+The dbus and gi modules are replaced with minimal stubs before import.
+Run with: python3 -m unittest test_pi_zero_ble_service
+"""
+
+import argparse
+import json
+import os
+import sys
+import tempfile
+import time
+import types
+import unittest
+from unittest import mock
+
+def install_stubs():
+    """Register stand-ins for the D-Bus and GLib modules the service imports"""
+    def passthrough(*args, **kwargs):
+        return lambda func: func
+
+    dbus = types.ModuleType('dbus')
+    dbus.exceptions = types.ModuleType('dbus.exceptions')
+    dbus.exceptions.DBusException = type('DBusException', (Exception,), {})
+    dbus.service = types.ModuleType('dbus.service')
+    dbus.service.Object = type('Object', (), {'__init__': lambda self, *args: None})
+    dbus.service.method = passthrough
+    dbus.service.signal = passthrough
+    dbus.mainloop = types.ModuleType('dbus.mainloop')
+    dbus.mainloop.glib = types.ModuleType('dbus.mainloop.glib')
+    dbus.ObjectPath = str
+    dbus.PROPERTIES_IFACE = 'org.freedesktop.DBus.Properties'
+
+    gi = types.ModuleType('gi')
+    gi.repository = types.ModuleType('gi.repository')
+    gi.repository.GLib = types.ModuleType('gi.repository.GLib')
+
+    for module in (dbus, dbus.exceptions, dbus.service, dbus.mainloop, dbus.mainloop.glib,
+                   gi, gi.repository):
+        sys.modules.setdefault(module.__name__, module)
+
+install_stubs()
+
+import pi_zero_ble_service as service_module
+
+def chunk(request_id, flags, data):
+    """Frame a request chunk the way clients write it"""
+    return list(request_id.encode('utf-8').ljust(16, b'\0') + bytes([flags]) + data)
+
+class FakeService:
+    """Records the requests the characteristic hands over for processing"""
+    def __init__(self):
+        self.pending_requests = {}
+        self.processed = []
+
+    def process_http_request(self, request):
+        self.processed.append(request)
+
+class ImmediateThread:
+    """Runs the target synchronously so tests do not race the worker thread"""
+    def __init__(self, target, args=()):
+        self.target = target
+        self.args = args
+
+    def start(self):
+        self.target(*self.args)
+
+class RequestCharacteristicTest(unittest.TestCase):
+    def setUp(self):
+        self.service = FakeService()
+        self.characteristic = service_module.HTTPRequestCharacteristic.__new__(
+            service_module.HTTPRequestCharacteristic)
+        self.characteristic.service = self.service
+        patcher = mock.patch.object(service_module.threading, 'Thread', ImmediateThread)
+        patcher.start()
+        self.addCleanup(patcher.stop)
+
+    def write(self, device, request_id, flags, data):
+        self.characteristic.WriteValue(chunk(request_id, flags, data), {'device': device})
+
+    def test_interleaved_requests_from_two_centrals_with_the_same_id(self):
+        first = b'GET /first HTTP/1.1\r\n\r\n'
+        second = b'GET /second HTTP/1.1\r\n\r\n'
+
+        self.write('/org/bluez/hci0/dev_A', 'same-id', 1, first[:10])
+        self.write('/org/bluez/hci0/dev_B', 'same-id', 1, second[:10])
+        self.write('/org/bluez/hci0/dev_A', 'same-id', 2, first[10:])
+        self.write('/org/bluez/hci0/dev_B', 'same-id', 2, second[10:])
+
+        self.assertEqual([bytes(r.data) for r in self.service.processed], [first, second])
+        self.assertEqual(self.service.pending_requests, {})
+
+    def test_continuation_from_another_central_is_dropped(self):
+        self.write('/org/bluez/hci0/dev_A', 'req', 1, b'GET / HTTP/1.1\r\n')
+        self.write('/org/bluez/hci0/dev_B', 'req', 2, b'\r\n')
+
+        self.assertEqual(self.service.processed, [])
+        self.assertIn(('/org/bluez/hci0/dev_A', 'req'), self.service.pending_requests)
+
+class StatusCharacteristicTest(unittest.TestCase):
+    def setUp(self):
+        service_module.start_time = time.time()
+        self.service = service_module.HTTPProxyService(None, 0, 8080)
+
+    def read_status(self, options):
+        return json.loads(bytes(self.service.status_characteristic.ReadValue(options)))
+
+    def test_reports_negotiated_mtu(self):
+        status = self.read_status({'mtu': 185, 'device': '/org/bluez/hci0/dev_A'})
+        self.assertEqual(status['mtu'], 185)
+        self.assertEqual(status['response_mtu'], service_module.RESPONSE_MTU)
+
+    def test_mtu_unknown_without_bluez_option(self):
+        self.assertIsNone(self.read_status({})['mtu'])
+
+class ReloadConfigTest(unittest.TestCase):
+    def setUp(self):
+        self.service = service_module.HTTPProxyService(None, 0, 8080)
+        handle, self.status_file = tempfile.mkstemp()
+        os.close(handle)
+        self.addCleanup(os.remove, self.status_file)
+        self.reload_file = f"{self.status_file}.reload"
+        self.addCleanup(os.remove, self.reload_file)
+        args = argparse.Namespace(device_name='NetTool', port=8080,
+                                  service_uuid=service_module.BLE_HTTP_PROXY_SERVICE_UUID)
+        for name, value in (('service', self.service), ('status_file', self.status_file),
+                            ('reload_file', self.reload_file), ('args', args), ('start_time', time.time()),
+                            ('current_status', 'running'), ('reload_result', {})):
+            patcher = mock.patch.object(service_module, name, value, create=True)
+            patcher.start()
+            self.addCleanup(patcher.stop)
+
+    def reload(self, settings):
+        with open(self.reload_file, 'w') as f:
+            json.dump(settings, f)
+        service_module.reload_config()
+
+    def test_routes_are_replaced_and_cleared(self):
+        self.reload({'upstreamScheme': 'http', 'routes': ['/=localhost:8080', '/api=localhost:9090']})
+        self.assertEqual(self.service.select_upstream('/api/users?page=2'), ('localhost', 9090))
+        self.assertEqual(self.service.select_upstream('/dashboard'), ('localhost', 8080))
+
+        self.reload({'upstreamScheme': 'http', 'upstreamAddr': '10.0.0.2:8000'})
+        self.assertEqual(self.service.routes, [])
+        self.assertEqual(self.service.select_upstream('/api'), ('10.0.0.2', 8000))
+
+    def test_invalid_route_keeps_current_settings(self):
+        self.reload({'upstreamScheme': 'http', 'routes': ['/api=localhost:9090']})
+        self.reload({'upstreamScheme': 'http', 'routes': ['api=nowhere']})
+        self.assertEqual(self.service.routes, [('/api', 'localhost', 9090)])
+
+    def read_status(self):
+        with open(self.status_file) as f:
+            return json.load(f)
+
+    def test_applied_reload_is_acknowledged(self):
+        self.reload({'upstreamScheme': 'http', 'routes': ['/api=localhost:9090'], 'generation': 3})
+        status = self.read_status()
+        self.assertEqual(status['reloadGeneration'], 3)
+        self.assertNotIn('reloadError', status)
+
+    def test_rejected_reload_is_acknowledged_with_error(self):
+        self.reload({'upstreamScheme': 'ftp', 'generation': 4})
+        status = self.read_status()
+        self.assertEqual(status['reloadGeneration'], 4)
+        self.assertIn('ftp', status['reloadError'])
+
+class RegistrationStatusTest(unittest.TestCase):
+    def setUp(self):
+        handle, self.status_file = tempfile.mkstemp()
+        os.close(handle)
+        self.addCleanup(os.remove, self.status_file)
+        args = argparse.Namespace(device_name='NetTool', port=8080,
+                                  service_uuid=service_module.BLE_HTTP_PROXY_SERVICE_UUID)
+        for name, value in (('status_file', self.status_file), ('args', args), ('start_time', time.time()),
+                            ('current_status', 'starting'),
+                            ('registration', {'serviceRegistered': False, 'advertising': False})):
+            patcher = mock.patch.object(service_module, name, value, create=True)
+            patcher.start()
+            self.addCleanup(patcher.stop)
+
+    def read_status(self):
+        with open(self.status_file) as f:
+            return json.load(f)
+
+    def test_registration_results_are_recorded(self):
+        service_module.on_advertisement_registered()
+        status = self.read_status()
+        self.assertEqual((status['status'], status['advertising'], status['serviceRegistered']),
+                         ('starting', True, False))
+
+        service_module.on_service_registered()
+        status = self.read_status()
+        self.assertEqual((status['status'], status['advertising'], status['serviceRegistered']),
+                         ('running', True, True))
+
+    def test_rejected_advertisement_is_recorded(self):
+        service_module.on_service_registered()
+        service_module.on_advertisement_error('org.bluez.Error.Failed')
+        status = self.read_status()
+        self.assertEqual((status['status'], status['advertising'], status['serviceRegistered']),
+                         ('running', False, True))
+
+if __name__ == '__main__':
+    unittest.main()